@@ -3,12 +3,17 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 // ACMEChallenge represents the supported acme challenges.
@@ -41,6 +46,399 @@ func (c ACMEChallenge) Validate() error {
 	}
 }
 
+// ACMEIdentifierType encodes ACME Identifier types
+type ACMEIdentifierType string
+
+const (
+	// IP is the ACME ip identifier type
+	IP ACMEIdentifierType = "ip"
+	// DNS is the ACME dns identifier type
+	DNS ACMEIdentifierType = "dns"
+)
+
+// ChallengePolicy scopes a challenge type to the identifiers it may be used
+// to validate. It mirrors the HostPolicy/HostWhitelist gating pattern used
+// by autocert, but is enforced server-side and per challenge instead of
+// globally for the provisioner.
+//
+// A ChallengePolicy may be configured in full, as a JSON object, or as a
+// bare challenge name (e.g. "http-01"), which is equivalent to a policy
+// with no IdentifierType or Patterns restriction.
+type ChallengePolicy struct {
+	// Challenge is the ACME challenge type this policy applies to.
+	Challenge ACMEChallenge `json:"challenge"`
+	// IdentifierType restricts the policy to identifiers of this type. If
+	// empty, the policy applies regardless of identifier type.
+	IdentifierType ACMEIdentifierType `json:"identifierType,omitempty"`
+	// Patterns restricts the policy to identifiers whose value matches one
+	// of these patterns. DNS patterns may use a single leading "*." label
+	// wildcard, as in x509 SAN matching. IP patterns may be an exact
+	// address or a CIDR block. If empty, any value of IdentifierType (or
+	// any value at all, if IdentifierType is also empty) is allowed.
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler so a ChallengePolicy can be
+// configured as a bare challenge name instead of a full object.
+func (cp *ChallengePolicy) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		cp.Challenge = ACMEChallenge(name)
+		cp.IdentifierType = ""
+		cp.Patterns = nil
+		return nil
+	}
+
+	type alias ChallengePolicy
+	var a alias
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	*cp = ChallengePolicy(a)
+	return nil
+}
+
+// Validate returns an error if the challenge policy is not valid.
+func (cp ChallengePolicy) Validate() error {
+	if err := cp.Challenge.Validate(); err != nil {
+		return err
+	}
+	switch cp.IdentifierType {
+	case "", IP, DNS:
+	default:
+		return fmt.Errorf("identifier type %q is not supported", cp.IdentifierType)
+	}
+	return nil
+}
+
+// allows reports whether the policy covers the given identifier.
+func (cp ChallengePolicy) allows(identifier ACMEIdentifier) bool {
+	if cp.IdentifierType != "" && cp.IdentifierType != identifier.Type {
+		return false
+	}
+	if len(cp.Patterns) == 0 {
+		return true
+	}
+	for _, pattern := range cp.Patterns {
+		if matchIdentifierPattern(identifier.Type, pattern, identifier.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIdentifierPattern reports whether value, an identifier of the given
+// type, matches pattern.
+func matchIdentifierPattern(typ ACMEIdentifierType, pattern, value string) bool {
+	switch typ {
+	case IP:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return false
+		}
+		if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+			return cidr.Contains(ip)
+		}
+		return net.ParseIP(pattern).Equal(ip)
+	case DNS:
+		pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+		if pattern == value {
+			return true
+		}
+		suffix, ok := strings.CutPrefix(pattern, "*.")
+		if !ok || !strings.HasSuffix(value, "."+suffix) {
+			return false
+		}
+		label := strings.TrimSuffix(value, "."+suffix)
+		return label != "" && !strings.Contains(label, ".")
+	default:
+		return false
+	}
+}
+
+// defaultChallengePolicies are used when an ACME provisioner does not
+// configure Challenges: http-01, dns-01 and tls-alpn-01 are enabled for
+// identifiers of any type and value, device-attest-01 is disabled.
+var defaultChallengePolicies = []ChallengePolicy{
+	{Challenge: HTTP_01},
+	{Challenge: DNS_01},
+	{Challenge: TLS_ALPN_01},
+}
+
+// ARIWindow is the suggested window in which a client should attempt
+// renewal, as returned in a renewalInfo response.
+type ARIWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ARIRenewalInfo is the response body for the ACME renewalInfo resource
+// defined in draft-ietf-acme-ari.
+type ARIRenewalInfo struct {
+	SuggestedWindow ARIWindow `json:"suggestedWindow"`
+	ExplanationURL  string    `json:"explanationURL,omitempty"`
+}
+
+// ARIWindowFunc computes the suggested renewal window for cert, the
+// certificate a renewalInfo request resolved to. Operators can set
+// ACME.ARIWindowFunc to bias the window for revoked or key-compromised
+// certificates, for example by returning a window in the past to prompt
+// clients to renew immediately.
+type ARIWindowFunc func(cert *x509.Certificate) (start, end time.Time)
+
+// defaultARIWindow suggests the middle third of the certificate's validity
+// period.
+func defaultARIWindow(cert *x509.Certificate) (time.Time, time.Time) {
+	third := cert.NotAfter.Sub(cert.NotBefore) / 3
+	return cert.NotBefore.Add(third), cert.NotBefore.Add(2 * third)
+}
+
+// ARICertID derives the ACME Renewal Information certificate identifier
+// defined in draft-ietf-acme-ari: base64url(AKI) + "." + base64url(serial).
+func ARICertID(cert *x509.Certificate) (string, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return "", errors.New("certificate has no authority key identifier")
+	}
+	return base64.RawURLEncoding.EncodeToString(cert.AuthorityKeyId) + "." +
+		base64.RawURLEncoding.EncodeToString(cert.SerialNumber.Bytes()), nil
+}
+
+// DefaultRateLimitRetryAfter is used as RateLimits.RetryAfter when it is
+// left unset.
+const DefaultRateLimitRetryAfter = 5 * time.Second
+
+// rateLimitRejectedTotal counts ACME requests rejected by a RateLimiter,
+// labeled by the limit class that was exceeded, so operators can tune
+// thresholds.
+var rateLimitRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "step_ca",
+	Subsystem: "acme",
+	Name:      "rate_limit_rejected_total",
+	Help:      "Total number of ACME requests rejected because a rate limit was exceeded, by limit class.",
+}, []string{"class"})
+
+// RateLimits configures per-account and per-domain ACME issuance limits
+// for a provisioner. A zero field disables the corresponding limit.
+type RateLimits struct {
+	// NewOrdersPerAccountPerHour caps how many new orders an account may
+	// create in a rolling hour.
+	NewOrdersPerAccountPerHour int `json:"newOrdersPerAccountPerHour,omitempty"`
+	// PendingOrdersPerAccount caps how many orders an account may have in
+	// the pending state at once.
+	PendingOrdersPerAccount int `json:"pendingOrdersPerAccount,omitempty"`
+	// CertificatesPerDomainPerWeek caps how many certificates may be
+	// issued for a given domain in a rolling week.
+	CertificatesPerDomainPerWeek int `json:"certificatesPerDomainPerWeek,omitempty"`
+	// FailedAuthorizationsPerHour caps how many authorizations an account
+	// may fail in a rolling hour before further authorizations, and the
+	// signing of the certificates they gate, are rejected. Requires the
+	// ACME API to call (*ACME).RecordFailedAuthorization whenever a
+	// challenge fails validation; the count is otherwise never
+	// incremented.
+	FailedAuthorizationsPerHour int `json:"failedAuthorizationsPerHour,omitempty"`
+	// RetryAfter is the minimum backoff a client must wait before retrying
+	// a request that was rejected by one of the limits above. It
+	// generalizes the retry-after behavior the CA already applies when a
+	// signing request fails. Defaults to DefaultRateLimitRetryAfter.
+	RetryAfter time.Duration `json:"retryAfter,omitempty"`
+}
+
+// RateLimiter decides whether an ACME account or domain has exceeded one of
+// the RateLimits configured on a provisioner. Implementations are expected
+// to be backed by the CA's DB, so counts survive CA restarts and are shared
+// across replicas.
+type RateLimiter interface {
+	// AuthorizeNewOrder reports whether accountID may create a new order,
+	// against NewOrdersPerAccountPerHour and PendingOrdersPerAccount. It
+	// does not count the order; callers must call RecordNewOrder once the
+	// order the check was guarding has actually been accepted.
+	AuthorizeNewOrder(ctx context.Context, accountID string) error
+	// RecordNewOrder records that accountID created a new order, counting
+	// towards NewOrdersPerAccountPerHour. Callers must only call this once
+	// every check gating the order - AuthorizeNewOrder and
+	// AuthorizeCertificate among them - has already succeeded, so an order
+	// rejected by a later check never consumes a slot.
+	RecordNewOrder(ctx context.Context, accountID string) error
+	// AuthorizeCertificate reports whether a certificate for names may be
+	// issued to accountID, against CertificatesPerDomainPerWeek. It does
+	// not count the certificate; callers must call RecordCertificate once
+	// the order the check was guarding has actually been accepted.
+	AuthorizeCertificate(ctx context.Context, accountID string, names []string) error
+	// RecordCertificate records that a certificate for names was issued to
+	// accountID, counting towards CertificatesPerDomainPerWeek. Callers
+	// must only call this once every check gating the order has already
+	// succeeded, for the same reason as RecordNewOrder.
+	RecordCertificate(ctx context.Context, accountID string, names []string) error
+	// AuthorizeAuthorization enforces FailedAuthorizationsPerHour for
+	// accountID.
+	AuthorizeAuthorization(ctx context.Context, accountID string) error
+	// RecordFailedAuthorization records that accountID failed an
+	// authorization challenge, counting towards FailedAuthorizationsPerHour.
+	// The ACME API must call this whenever challenge validation fails;
+	// AuthorizeAuthorization only reads the count RecordFailedAuthorization
+	// writes, it never increments it itself.
+	RecordFailedAuthorization(ctx context.Context, accountID string) error
+}
+
+// RateLimitError is returned by a RateLimiter when a limit has been
+// exceeded. The ACME handler surfaces it as
+// urn:ietf:params:acme:error:rateLimited, with a Retry-After header set
+// from RetryAfter.
+type RateLimitError struct {
+	// Class identifies the exceeded limit, e.g.
+	// "NewOrdersPerAccountPerHour", and is used as the rateLimitRejectedTotal
+	// metric label.
+	Class string
+	// RetryAfter is how long the client should wait before retrying.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit %q exceeded, retry after %s", e.Class, e.RetryAfter)
+}
+
+// memoryRateLimiter is the default RateLimiter: in-process sliding-window
+// counters. It enforces NewOrdersPerAccountPerHour,
+// CertificatesPerDomainPerWeek and FailedAuthorizationsPerHour. It has no
+// visibility into order completion, so it cannot enforce
+// PendingOrdersPerAccount, that limit is a no-op unless ACME.Limiter is set
+// to an implementation backed by the CA's DB. Its counters also reset on
+// restart and aren't shared across replicas, set ACME.Limiter for either of
+// those to matter in a production deployment.
+type memoryRateLimiter struct {
+	limits *RateLimits
+
+	mu     sync.Mutex
+	orders map[string][]time.Time
+	certs  map[string][]time.Time
+	fails  map[string][]time.Time
+}
+
+// newMemoryRateLimiter returns the default RateLimiter for limits.
+func newMemoryRateLimiter(limits *RateLimits) *memoryRateLimiter {
+	return &memoryRateLimiter{
+		limits: limits,
+		orders: make(map[string][]time.Time),
+		certs:  make(map[string][]time.Time),
+		fails:  make(map[string][]time.Time),
+	}
+}
+
+// pruneBefore returns the subset of ts strictly after cutoff, reusing ts'
+// backing array.
+func pruneBefore(ts []time.Time, cutoff time.Time) []time.Time {
+	kept := ts[:0]
+	for _, t := range ts {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func (l *memoryRateLimiter) AuthorizeNewOrder(ctx context.Context, accountID string) error {
+	if l.limits.NewOrdersPerAccountPerHour <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.orders[accountID] = pruneBefore(l.orders[accountID], time.Now().Add(-time.Hour))
+	if len(l.orders[accountID]) >= l.limits.NewOrdersPerAccountPerHour {
+		return &RateLimitError{Class: "NewOrdersPerAccountPerHour", RetryAfter: l.limits.RetryAfter}
+	}
+	return nil
+}
+
+func (l *memoryRateLimiter) RecordNewOrder(ctx context.Context, accountID string) error {
+	if l.limits.NewOrdersPerAccountPerHour <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.orders[accountID] = append(l.orders[accountID], time.Now())
+	return nil
+}
+
+func (l *memoryRateLimiter) AuthorizeCertificate(ctx context.Context, accountID string, names []string) error {
+	if l.limits.CertificatesPerDomainPerWeek <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := time.Now().Add(-7 * 24 * time.Hour)
+	for _, name := range names {
+		l.certs[name] = pruneBefore(l.certs[name], cutoff)
+		if len(l.certs[name]) >= l.limits.CertificatesPerDomainPerWeek {
+			return &RateLimitError{Class: "CertificatesPerDomainPerWeek", RetryAfter: l.limits.RetryAfter}
+		}
+	}
+	return nil
+}
+
+func (l *memoryRateLimiter) RecordCertificate(ctx context.Context, accountID string, names []string) error {
+	if l.limits.CertificatesPerDomainPerWeek <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, name := range names {
+		l.certs[name] = append(l.certs[name], now)
+	}
+	return nil
+}
+
+func (l *memoryRateLimiter) AuthorizeAuthorization(ctx context.Context, accountID string) error {
+	if l.limits.FailedAuthorizationsPerHour <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fails[accountID] = pruneBefore(l.fails[accountID], time.Now().Add(-time.Hour))
+	if len(l.fails[accountID]) >= l.limits.FailedAuthorizationsPerHour {
+		return &RateLimitError{Class: "FailedAuthorizationsPerHour", RetryAfter: l.limits.RetryAfter}
+	}
+	return nil
+}
+
+func (l *memoryRateLimiter) RecordFailedAuthorization(ctx context.Context, accountID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fails[accountID] = append(l.fails[accountID], time.Now())
+	return nil
+}
+
+// acmeAccountIDKey is the context key under which the authenticated ACME
+// account ID is stored, so AuthorizeSign and AuthorizeOrder can rate-limit
+// per account without changing the shared Provisioner interface, which
+// otherwise only carries a token.
+type acmeAccountIDKey struct{}
+
+// NewContextWithAccountID returns a context carrying accountID, the
+// authenticated ACME account making the request, for per-account
+// RateLimits enforcement.
+func NewContextWithAccountID(ctx context.Context, accountID string) context.Context {
+	return context.WithValue(ctx, acmeAccountIDKey{}, accountID)
+}
+
+// accountIDFromContext returns the ACME account ID stored in ctx by
+// NewContextWithAccountID, or the empty string if none is set.
+func accountIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(acmeAccountIDKey{}).(string)
+	return id
+}
+
 // ACME is the acme provisioner type, an entity that can authorize the ACME
 // provisioning flow.
 type ACME struct {
@@ -54,14 +452,37 @@ type ACME struct {
 	// EAB will be verified. If set to false and an EAB is provided, it is
 	// not verified. Defaults to false.
 	RequireEAB bool `json:"requireEAB,omitempty"`
-	// Challenges contains the enabled challenges for this provisioner. If this
-	// value is not set the default http-01, dns-01 and tls-alpn-01 challenges
-	// will be enabled, device-attest-01 will be disabled.
-	Challenges []ACMEChallenge `json:"challenges,omitempty"`
-	Claims     *Claims         `json:"claims,omitempty"`
-	Options    *Options        `json:"options,omitempty"`
-
-	ctl *Controller
+	// Challenges contains the enabled challenges for this provisioner,
+	// optionally scoped to specific identifier types and name patterns. If
+	// this value is not set, defaultChallengePolicies is used: http-01,
+	// dns-01 and tls-alpn-01 will be enabled for any identifier,
+	// device-attest-01 will be disabled.
+	//
+	// This field's type changed from []ACMEChallenge to []ChallengePolicy.
+	// JSON configuration (a list of bare challenge names) is unaffected,
+	// since ChallengePolicy.UnmarshalJSON still accepts a bare string in
+	// place of an object, but Go code constructing an ACME literal with
+	// Challenges: []ACMEChallenge{...} needs updating to
+	// []ChallengePolicy{...}.
+	Challenges []ChallengePolicy `json:"challenges,omitempty"`
+	Claims     *Claims           `json:"claims,omitempty"`
+	Options    *Options          `json:"options,omitempty"`
+	// ARIWindowFunc, if set, overrides defaultARIWindow when computing the
+	// suggestedWindow of a renewalInfo response. It is not configurable
+	// through JSON; operators embedding the CA set it directly.
+	ARIWindowFunc ARIWindowFunc `json:"-"`
+	// RateLimits configures per-account and per-domain issuance limits for
+	// this provisioner. If nil, no rate limiting is performed.
+	RateLimits *RateLimits `json:"rateLimits,omitempty"`
+	// Limiter overrides the default in-memory RateLimiter with one backed
+	// by persistent storage (e.g. the CA's DB), so limits survive restarts
+	// and are shared across replicas. Wired up by the authority package at
+	// startup for deployments that need that; not configurable through
+	// JSON. Ignored if RateLimits is nil.
+	Limiter RateLimiter `json:"-"`
+
+	ctl     *Controller
+	limiter RateLimiter
 }
 
 // GetID returns the provisioner unique identifier.
@@ -78,6 +499,18 @@ func (p *ACME) GetIDForToken() string {
 	return "acme/" + p.Name
 }
 
+// RenewalInfoURL returns the renewalInfo resource URL for this provisioner,
+// to be advertised as the "renewalInfo" entry of its ACME directory.
+// dirURL is the provisioner's own directory URL, e.g.
+// https://ca.example.com/acme/<name>/directory.
+//
+// This only formats the URL; wiring it into the served directory object
+// and routing the renewalInfo HTTP resource to GetRenewalInfo is done by
+// the ACME API layer, which lives outside the provisioner package.
+func (p *ACME) RenewalInfoURL(dirURL string) string {
+	return strings.TrimSuffix(dirURL, "/directory") + "/renewal-info"
+}
+
 // GetTokenID returns the identifier of the token.
 func (p *ACME) GetTokenID(ott string) (string, error) {
 	return "", errors.New("acme provisioner does not implement GetTokenID")
@@ -118,61 +551,96 @@ func (p *ACME) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	}
 
-	for _, c := range p.Challenges {
-		if err := c.Validate(); err != nil {
+	for _, cp := range p.Challenges {
+		if err := cp.Validate(); err != nil {
 			return err
 		}
 	}
 
+	if p.RateLimits != nil {
+		if p.RateLimits.RetryAfter == 0 {
+			p.RateLimits.RetryAfter = DefaultRateLimitRetryAfter
+		}
+		p.limiter = p.Limiter
+		if p.limiter == nil {
+			p.limiter = newMemoryRateLimiter(p.RateLimits)
+		}
+	}
+
 	p.ctl, err = NewController(p, p.Claims, config, p.Options)
 	return
 }
 
-// ACMEIdentifierType encodes ACME Identifier types
-type ACMEIdentifierType string
-
-const (
-	// IP is the ACME ip identifier type
-	IP ACMEIdentifierType = "ip"
-	// DNS is the ACME dns identifier type
-	DNS ACMEIdentifierType = "dns"
-)
-
 // ACMEIdentifier encodes ACME Order Identifiers
 type ACMEIdentifier struct {
 	Type  ACMEIdentifierType
 	Value string
 }
 
+// challengePolicies returns the configured challenge policies, or
+// defaultChallengePolicies if none are configured.
+func (p *ACME) challengePolicies() []ChallengePolicy {
+	if len(p.Challenges) > 0 {
+		return p.Challenges
+	}
+	return defaultChallengePolicies
+}
+
+// identifierHasEnabledChallenge reports whether at least one enabled
+// challenge policy covers identifier.
+func (p *ACME) identifierHasEnabledChallenge(identifier ACMEIdentifier) bool {
+	for _, cp := range p.challengePolicies() {
+		if cp.allows(identifier) {
+			return true
+		}
+	}
+	return false
+}
+
 // AuthorizeOrderIdentifier verifies the provisioner is allowed to issue a
 // certificate for an ACME Order Identifier.
 func (p *ACME) AuthorizeOrderIdentifier(ctx context.Context, identifier ACMEIdentifier) error {
+	// identifier type must be valid regardless of whether a host policy is
+	// configured below
+	switch identifier.Type {
+	case IP, DNS:
+	default:
+		return fmt.Errorf("invalid ACME identifier type '%s' provided", identifier.Type)
+	}
 
-	x509Policy := p.ctl.getPolicy().getX509()
-
-	// identifier is allowed if no policy is configured
-	if x509Policy == nil {
-		return nil
+	if x509Policy := p.ctl.getPolicy().getX509(); x509Policy != nil {
+		var err error
+		switch identifier.Type {
+		case IP:
+			err = x509Policy.IsIPAllowed(net.ParseIP(identifier.Value))
+		case DNS:
+			err = x509Policy.IsDNSAllowed(identifier.Value)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	// assuming only valid identifiers (IP or DNS) are provided
-	var err error
-	switch identifier.Type {
-	case IP:
-		err = x509Policy.IsIPAllowed(net.ParseIP(identifier.Value))
-	case DNS:
-		err = x509Policy.IsDNSAllowed(identifier.Value)
-	default:
-		err = fmt.Errorf("invalid ACME identifier type '%s' provided", identifier.Type)
+	if !p.identifierHasEnabledChallenge(identifier) {
+		return fmt.Errorf("no enabled challenge allows identifier %q of type %q", identifier.Value, identifier.Type)
 	}
 
-	return err
+	return nil
 }
 
-// AuthorizeSign does not do any validation, because all validation is handled
-// in the ACME protocol. This method returns a list of modifiers / constraints
-// on the resulting certificate.
+// AuthorizeSign does not do any certificate-request validation, because all
+// of that is handled in the ACME protocol. It does, however, enforce
+// RateLimits.FailedAuthorizationsPerHour for the account making the
+// request, returning a *RateLimitError if it has been exceeded. Otherwise
+// it returns a list of modifiers / constraints on the resulting
+// certificate.
 func (p *ACME) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.limiter != nil {
+		if err := p.limiter.AuthorizeAuthorization(ctx, accountIDFromContext(ctx)); err != nil {
+			return nil, p.recordRateLimit(err)
+		}
+	}
+
 	opts := []SignOption{
 		p,
 		// modifiers / withOptions
@@ -188,6 +656,115 @@ func (p *ACME) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 	return opts, nil
 }
 
+// ACMEReplacesOption is the SignOption produced by AuthorizeOrder when the
+// client supplied a "replaces" certID. The signing authority is expected
+// to type-switch on it, alongside the other SignOptions AuthorizeSign
+// returns, to record the supersession once the new certificate has been
+// issued (e.g. in the ARI store backing GetRenewalInfo), marking CertID as
+// renewed.
+type ACMEReplacesOption struct {
+	// CertID is the ARI certID (see ARICertID) of the certificate that the
+	// certificate being signed supersedes.
+	CertID string
+}
+
+// newACMEReplacesOption returns a SignOption recording that the
+// certificate about to be issued supersedes the one identified by certID.
+func newACMEReplacesOption(certID string) SignOption {
+	return &ACMEReplacesOption{CertID: certID}
+}
+
+// NewOrderOptions carries ACME new-order request details beyond the
+// requested identifiers, needed to authorize the resulting certificate.
+type NewOrderOptions struct {
+	// Identifiers are the identifiers requested by the new-order, used to
+	// enforce RateLimits.CertificatesPerDomainPerWeek.
+	Identifiers []ACMEIdentifier
+	// Replaces is the ARI certID (see ARICertID) of the certificate this
+	// order supersedes, as submitted by the client in the "replaces" field
+	// of a draft-ietf-acme-ari new-order request. Empty if the client did
+	// not request a replacement.
+	Replaces string
+}
+
+// AuthorizeOrder does the same as AuthorizeSign, but additionally takes
+// order-level request metadata not available from the token alone - the
+// requested identifiers and the ARI "replaces" field. Before calling
+// AuthorizeSign it enforces RateLimits.NewOrdersPerAccountPerHour and
+// RateLimits.CertificatesPerDomainPerWeek for the account and identifiers,
+// returning a *RateLimitError if either has been exceeded, and records the
+// order against both limits once every check has passed. If Replaces is
+// set, it appends a SignOption carrying it, so the issued certificate can
+// be linked to the one it supersedes.
+func (p *ACME) AuthorizeOrder(ctx context.Context, token string, opts NewOrderOptions) ([]SignOption, error) {
+	var names []string
+	if len(opts.Identifiers) > 0 {
+		names = make([]string, len(opts.Identifiers))
+		for i, id := range opts.Identifiers {
+			names[i] = id.Value
+		}
+	}
+
+	accountID := accountIDFromContext(ctx)
+	if p.limiter != nil {
+		if err := p.limiter.AuthorizeNewOrder(ctx, accountID); err != nil {
+			return nil, p.recordRateLimit(err)
+		}
+		if len(names) > 0 {
+			if err := p.limiter.AuthorizeCertificate(ctx, accountID, names); err != nil {
+				return nil, p.recordRateLimit(err)
+			}
+		}
+	}
+
+	signOpts, err := p.AuthorizeSign(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Replaces != "" {
+		signOpts = append(signOpts, newACMEReplacesOption(opts.Replaces))
+	}
+
+	// Only now that every check above has accepted the order do we count
+	// it against NewOrdersPerAccountPerHour and CertificatesPerDomainPerWeek,
+	// so an order rejected by a later check never consumes a slot.
+	if p.limiter != nil {
+		if err := p.limiter.RecordNewOrder(ctx, accountID); err != nil {
+			return nil, p.recordRateLimit(err)
+		}
+		if len(names) > 0 {
+			if err := p.limiter.RecordCertificate(ctx, accountID, names); err != nil {
+				return nil, p.recordRateLimit(err)
+			}
+		}
+	}
+
+	return signOpts, nil
+}
+
+// recordRateLimit increments rateLimitRejectedTotal for err's limit class,
+// if err is a *RateLimitError, and returns err unchanged.
+func (p *ACME) recordRateLimit(err error) error {
+	var rlErr *RateLimitError
+	if errors.As(err, &rlErr) {
+		rateLimitRejectedTotal.WithLabelValues(rlErr.Class).Inc()
+	}
+	return err
+}
+
+// RecordFailedAuthorization records a failed authorization for accountID
+// against RateLimits.FailedAuthorizationsPerHour. The ACME API must call
+// this whenever a challenge fails validation - AuthorizeSign only reads
+// the count this writes, so without these calls
+// FailedAuthorizationsPerHour can never trigger. No-op if RateLimits is
+// not configured.
+func (p *ACME) RecordFailedAuthorization(ctx context.Context, accountID string) error {
+	if p.limiter == nil {
+		return nil
+	}
+	return p.limiter.RecordFailedAuthorization(ctx, accountID)
+}
+
 // AuthorizeRevoke is called just before the certificate is to be revoked by
 // the CA. It can be used to authorize revocation of a certificate. It
 // currently is a no-op.
@@ -205,18 +782,46 @@ func (p *ACME) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error
 	return p.ctl.AuthorizeRenew(ctx, cert)
 }
 
-// IsChallengeEnabled checks if the given challenge is enabled. By default
-// http-01, dns-01 and tls-alpn-01 are enabled, to disable any of them the
-// Challenge provisioner property should have at least one element.
-func (p *ACME) IsChallengeEnabled(ctx context.Context, challenge ACMEChallenge) bool {
-	enabledChallenges := []ACMEChallenge{
-		HTTP_01, DNS_01, TLS_ALPN_01,
+// GetRenewalInfo returns the ACME renewalInfo resource for cert. If
+// replaced is true, cert has already been replaced by a newer certificate
+// (see AuthorizeOrder / NewOrderOptions.Replaces) and the window is set to
+// the single instant of cert's validity start, a window in the past, which
+// prompts clients still holding cert to renew immediately. Otherwise the
+// window is computed by ARIWindowFunc, or defaultARIWindow if unset.
+func (p *ACME) GetRenewalInfo(cert *x509.Certificate, replaced bool) ARIRenewalInfo {
+	if replaced {
+		return ARIRenewalInfo{SuggestedWindow: ARIWindow{Start: cert.NotBefore, End: cert.NotBefore}}
 	}
-	if len(p.Challenges) > 0 {
-		enabledChallenges = p.Challenges
+
+	windowFunc := defaultARIWindow
+	if p.ARIWindowFunc != nil {
+		windowFunc = p.ARIWindowFunc
+	}
+	start, end := windowFunc(cert)
+	return ARIRenewalInfo{SuggestedWindow: ARIWindow{Start: start, End: end}}
+}
+
+// IsChallengeEnabled checks if the given challenge is enabled for at least
+// one identifier. By default http-01, dns-01 and tls-alpn-01 are enabled,
+// to disable any of them the Challenge provisioner property should have at
+// least one element. To check whether a challenge is enabled for a
+// specific identifier, taking any configured IdentifierType or Patterns
+// restriction into account, use IsChallengeEnabledForIdentifier instead.
+func (p *ACME) IsChallengeEnabled(ctx context.Context, challenge ACMEChallenge) bool {
+	for _, cp := range p.challengePolicies() {
+		if strings.EqualFold(string(cp.Challenge), string(challenge)) {
+			return true
+		}
 	}
-	for _, ch := range enabledChallenges {
-		if strings.EqualFold(string(ch), string(challenge)) {
+	return false
+}
+
+// IsChallengeEnabledForIdentifier checks if the given challenge is enabled
+// for identifier, honoring any per-challenge IdentifierType and Patterns
+// restriction configured in Challenges.
+func (p *ACME) IsChallengeEnabledForIdentifier(ctx context.Context, challenge ACMEChallenge, identifier ACMEIdentifier) bool {
+	for _, cp := range p.challengePolicies() {
+		if strings.EqualFold(string(cp.Challenge), string(challenge)) && cp.allows(identifier) {
 			return true
 		}
 	}
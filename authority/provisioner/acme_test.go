@@ -0,0 +1,250 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchIdentifierPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     ACMEIdentifierType
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"dns exact match", DNS, "www.example.com", "www.example.com", true},
+		{"dns exact mismatch", DNS, "www.example.com", "api.example.com", false},
+		{"dns wildcard match", DNS, "*.internal.example.com", "host.internal.example.com", true},
+		{"dns wildcard case insensitive", DNS, "*.Internal.Example.com", "HOST.internal.example.com", true},
+		{"dns wildcard does not match base domain", DNS, "*.internal.example.com", "internal.example.com", false},
+		{"dns wildcard does not match multiple labels", DNS, "*.internal.example.com", "a.b.internal.example.com", false},
+		{"dns wildcard wrong suffix", DNS, "*.internal.example.com", "host.example.com", false},
+		{"ip exact match", IP, "10.0.0.1", "10.0.0.1", true},
+		{"ip exact mismatch", IP, "10.0.0.1", "10.0.0.2", false},
+		{"ip cidr match", IP, "10.0.0.0/24", "10.0.0.42", true},
+		{"ip cidr mismatch", IP, "10.0.0.0/24", "10.0.1.42", false},
+		{"ip invalid value", IP, "10.0.0.0/24", "not-an-ip", false},
+		{"unsupported identifier type", ACMEIdentifierType("urn"), "anything", "anything", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchIdentifierPattern(tt.typ, tt.pattern, tt.value))
+		})
+	}
+}
+
+func TestChallengePolicy_allows(t *testing.T) {
+	tests := []struct {
+		name string
+		cp   ChallengePolicy
+		id   ACMEIdentifier
+		want bool
+	}{
+		{
+			name: "no restrictions allows any identifier",
+			cp:   ChallengePolicy{Challenge: HTTP_01},
+			id:   ACMEIdentifier{Type: DNS, Value: "example.com"},
+			want: true,
+		},
+		{
+			name: "identifier type mismatch",
+			cp:   ChallengePolicy{Challenge: TLS_ALPN_01, IdentifierType: IP},
+			id:   ACMEIdentifier{Type: DNS, Value: "example.com"},
+			want: false,
+		},
+		{
+			name: "pattern match",
+			cp:   ChallengePolicy{Challenge: HTTP_01, IdentifierType: DNS, Patterns: []string{"*.internal.example.com"}},
+			id:   ACMEIdentifier{Type: DNS, Value: "host.internal.example.com"},
+			want: true,
+		},
+		{
+			name: "pattern mismatch",
+			cp:   ChallengePolicy{Challenge: HTTP_01, IdentifierType: DNS, Patterns: []string{"*.internal.example.com"}},
+			id:   ACMEIdentifier{Type: DNS, Value: "host.external.example.com"},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cp.allows(tt.id))
+		})
+	}
+}
+
+func TestChallengePolicy_UnmarshalJSON(t *testing.T) {
+	t.Run("bare string", func(t *testing.T) {
+		var cp ChallengePolicy
+		err := cp.UnmarshalJSON([]byte(`"http-01"`))
+		assert.NoError(t, err)
+		assert.Equal(t, ChallengePolicy{Challenge: HTTP_01}, cp)
+	})
+	t.Run("object", func(t *testing.T) {
+		var cp ChallengePolicy
+		err := cp.UnmarshalJSON([]byte(`{"challenge":"dns-01","identifierType":"dns","patterns":["*.example.com"]}`))
+		assert.NoError(t, err)
+		assert.Equal(t, ChallengePolicy{Challenge: DNS_01, IdentifierType: DNS, Patterns: []string{"*.example.com"}}, cp)
+	})
+}
+
+func TestACME_AuthorizeOrderIdentifier_invalidType(t *testing.T) {
+	p := &ACME{ctl: &Controller{}}
+	err := p.AuthorizeOrderIdentifier(context.Background(), ACMEIdentifier{Type: "urn", Value: "example.com"})
+	assert.Error(t, err)
+}
+
+func TestARICertID(t *testing.T) {
+	t.Run("missing authority key id", func(t *testing.T) {
+		cert := &x509.Certificate{SerialNumber: big.NewInt(1)}
+		_, err := ARICertID(cert)
+		assert.Error(t, err)
+	})
+	t.Run("derives from aki and serial", func(t *testing.T) {
+		aki := []byte{0x01, 0x02, 0x03}
+		serial := big.NewInt(65535)
+		cert := &x509.Certificate{AuthorityKeyId: aki, SerialNumber: serial}
+
+		id, err := ARICertID(cert)
+		assert.NoError(t, err)
+		want := base64.RawURLEncoding.EncodeToString(aki) + "." + base64.RawURLEncoding.EncodeToString(serial.Bytes())
+		assert.Equal(t, want, id)
+	})
+	t.Run("known single-byte vector", func(t *testing.T) {
+		cert := &x509.Certificate{
+			AuthorityKeyId: []byte{0x01},
+			SerialNumber:   big.NewInt(1),
+		}
+		id, err := ARICertID(cert)
+		assert.NoError(t, err)
+		assert.Equal(t, "AQ.AQ", id)
+	})
+}
+
+func TestDefaultARIWindow(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(90 * 24 * time.Hour)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notAfter}
+
+	start, end := defaultARIWindow(cert)
+
+	third := notAfter.Sub(notBefore) / 3
+	assert.Equal(t, notBefore.Add(third), start)
+	assert.Equal(t, notBefore.Add(2*third), end)
+	assert.True(t, start.Before(end))
+}
+
+func TestACME_GetRenewalInfo(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(90 * 24 * time.Hour)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notAfter}
+
+	t.Run("default window", func(t *testing.T) {
+		p := &ACME{}
+		info := p.GetRenewalInfo(cert, false)
+		start, end := defaultARIWindow(cert)
+		assert.Equal(t, start, info.SuggestedWindow.Start)
+		assert.Equal(t, end, info.SuggestedWindow.End)
+	})
+
+	t.Run("replaced forces immediate renewal", func(t *testing.T) {
+		p := &ACME{}
+		info := p.GetRenewalInfo(cert, true)
+		assert.Equal(t, notBefore, info.SuggestedWindow.Start)
+		assert.Equal(t, notBefore, info.SuggestedWindow.End)
+	})
+
+	t.Run("custom window func overrides default", func(t *testing.T) {
+		forced := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		p := &ACME{ARIWindowFunc: func(*x509.Certificate) (time.Time, time.Time) {
+			return forced, forced
+		}}
+		info := p.GetRenewalInfo(cert, false)
+		assert.Equal(t, forced, info.SuggestedWindow.Start)
+		assert.Equal(t, forced, info.SuggestedWindow.End)
+	})
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("NewOrdersPerAccountPerHour", func(t *testing.T) {
+		l := newMemoryRateLimiter(&RateLimits{NewOrdersPerAccountPerHour: 2, RetryAfter: time.Second})
+		assert.NoError(t, l.AuthorizeNewOrder(ctx, "acct1"))
+		assert.NoError(t, l.RecordNewOrder(ctx, "acct1"))
+		assert.NoError(t, l.AuthorizeNewOrder(ctx, "acct1"))
+		assert.NoError(t, l.RecordNewOrder(ctx, "acct1"))
+
+		err := l.AuthorizeNewOrder(ctx, "acct1")
+		assert.Error(t, err)
+		var rlErr *RateLimitError
+		assert.ErrorAs(t, err, &rlErr)
+		assert.Equal(t, "NewOrdersPerAccountPerHour", rlErr.Class)
+		assert.Equal(t, time.Second, rlErr.RetryAfter)
+
+		// checking again without recording doesn't consume another slot
+		assert.Error(t, l.AuthorizeNewOrder(ctx, "acct1"))
+
+		// a different account is unaffected
+		assert.NoError(t, l.AuthorizeNewOrder(ctx, "acct2"))
+	})
+
+	t.Run("CertificatesPerDomainPerWeek", func(t *testing.T) {
+		l := newMemoryRateLimiter(&RateLimits{CertificatesPerDomainPerWeek: 1})
+		assert.NoError(t, l.AuthorizeCertificate(ctx, "acct1", []string{"example.com"}))
+		assert.NoError(t, l.RecordCertificate(ctx, "acct1", []string{"example.com"}))
+
+		err := l.AuthorizeCertificate(ctx, "acct1", []string{"example.com"})
+		assert.Error(t, err)
+
+		// checking again without recording doesn't consume another slot
+		assert.Error(t, l.AuthorizeCertificate(ctx, "acct1", []string{"example.com"}))
+
+		// a different domain is unaffected
+		assert.NoError(t, l.AuthorizeCertificate(ctx, "acct1", []string{"other.example.com"}))
+	})
+
+	t.Run("FailedAuthorizationsPerHour requires RecordFailedAuthorization", func(t *testing.T) {
+		l := newMemoryRateLimiter(&RateLimits{FailedAuthorizationsPerHour: 1})
+		assert.NoError(t, l.AuthorizeAuthorization(ctx, "acct1"))
+
+		assert.NoError(t, l.RecordFailedAuthorization(ctx, "acct1"))
+
+		err := l.AuthorizeAuthorization(ctx, "acct1")
+		assert.Error(t, err)
+	})
+
+	t.Run("zero limit disables the check", func(t *testing.T) {
+		l := newMemoryRateLimiter(&RateLimits{})
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, l.AuthorizeNewOrder(ctx, "acct1"))
+		}
+	})
+}
+
+func TestACME_RecordFailedAuthorization(t *testing.T) {
+	t.Run("no-op without RateLimits", func(t *testing.T) {
+		p := &ACME{}
+		assert.NoError(t, p.RecordFailedAuthorization(context.Background(), "acct1"))
+	})
+
+	t.Run("feeds AuthorizeAuthorization through the limiter", func(t *testing.T) {
+		limits := &RateLimits{FailedAuthorizationsPerHour: 1}
+		p := &ACME{RateLimits: limits, limiter: newMemoryRateLimiter(limits)}
+
+		ctx := NewContextWithAccountID(context.Background(), "acct1")
+		assert.NoError(t, p.RecordFailedAuthorization(ctx, "acct1"))
+
+		// AuthorizeSign checks the limiter before touching anything else on
+		// p, so this errors out before it would otherwise panic on p.ctl
+		// being nil.
+		_, err := p.AuthorizeSign(ctx, "")
+		assert.Error(t, err)
+	})
+}